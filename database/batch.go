@@ -0,0 +1,23 @@
+package database
+
+// BatchUpdater is an optional interface, analogous to Finisher and
+// Deployer, implemented by backends that can apply a batch of row
+// inserts and deletes inside a single transaction. The diff subsystem
+// uses it to apply an OsmChange changeset atomically instead of issuing
+// one statement per element.
+type BatchUpdater interface {
+	// BeginBatch starts a new transactional batch. size is a hint (taken
+	// from GOPOSM_DBIMPORT_BATCHSIZE) for how many rows the caller
+	// intends to buffer before the batch is committed.
+	BeginBatch(size int64) error
+	// InsertBatch inserts or replaces a row for the given table as part
+	// of the current batch.
+	InsertBatch(table string, row []interface{}) error
+	// DeleteBatch removes the row with the given OSM id from table as
+	// part of the current batch.
+	DeleteBatch(table string, id int64) error
+	// CommitBatch commits the current batch.
+	CommitBatch() error
+	// RollbackBatch discards the current batch.
+	RollbackBatch() error
+}
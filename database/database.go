@@ -0,0 +1,114 @@
+// Package database defines the interface output backends implement, and
+// a database/sql-style registry so that backends other than PostGIS can
+// be selected at runtime through the -connection flag.
+package database
+
+import (
+	"fmt"
+	"goposm/element"
+	"goposm/mapping"
+	"strings"
+)
+
+// ConnectionType identifies an output backend, e.g. "postgis", "gpkg" or
+// "flatgeobuf". It is derived from the scheme of a URL-style -connection
+// value (postgis:// is also accepted for the built-in driver, but for
+// backwards compatibility a -connection without a scheme is still
+// treated as a postgis DSN).
+type ConnectionType string
+
+// Config bundles everything a Driver needs to open a DB for an import.
+type Config struct {
+	Type             ConnectionType
+	ConnectionParams string
+	Srid             int
+	Mapping          *mapping.Mapping
+}
+
+// Geometry is a backend-independent handle to a geometry that was
+// already projected/simplified by the writer pipeline, ready to be
+// serialized by whichever Driver is selected.
+type Geometry struct {
+	Wkb   []byte
+	Valid bool
+}
+
+// DB is implemented by every output backend. The writer pipeline feeds
+// features to it; Finisher/Deployer/BatchUpdater are optional
+// capabilities a backend may additionally support.
+type DB interface {
+	Init() error
+	InsertPoint(elem element.Node, geom Geometry, matches []mapping.Match) error
+	InsertLineString(elem element.Way, geom Geometry, matches []mapping.Match) error
+	InsertPolygon(elem element.Way, geom Geometry, matches []mapping.Match) error
+	Close() error
+}
+
+// Finisher is implemented by backends that need a final step after all
+// features were inserted, e.g. building indexes or swapping in a
+// production table.
+type Finisher interface {
+	Finish() error
+}
+
+// Deployer is implemented by backends that support blue/green deploys
+// via -deployproduction/-revertdeploy/-removebackup.
+type Deployer interface {
+	Deploy() error
+	RevertDeploy() error
+	RemoveBackup() error
+}
+
+// Driver is implemented by a package that wants to register itself as
+// an output backend, analogous to database/sql.Driver. Backends
+// register themselves from an init() function via Register.
+type Driver interface {
+	Open(conf Config) (DB, error)
+}
+
+var drivers = make(map[ConnectionType]Driver)
+
+// Register makes a Driver available under name. It is meant to be
+// called from the init() function of a backend package, which is then
+// blank-imported by main for its side effect, e.g.:
+//
+//	import _ "goposm/database/postgis"
+func Register(name ConnectionType, driver Driver) {
+	if driver == nil {
+		panic("database: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("database: Register called twice for driver " + string(name))
+	}
+	drivers[name] = driver
+}
+
+// Open parses conf.ConnectionParams for a URL-style scheme (e.g.
+// gpkg:///tmp/out.gpkg, flatgeobuf:///tmp/out.fgb) to determine which
+// registered Driver to use, and hands off to it. A -connection without
+// a "scheme://" prefix is treated as a plain postgis DSN for backwards
+// compatibility with earlier goposm releases.
+func Open(conf Config) (DB, error) {
+	if conf.Type == "" {
+		conf.Type = connectionType(conf.ConnectionParams)
+	}
+	driver, ok := drivers[conf.Type]
+	if !ok {
+		return nil, fmt.Errorf("database: unknown driver %q (forgotten import?)", conf.Type)
+	}
+	return driver.Open(conf)
+}
+
+// ConnectionTypeOf extracts the driver name from a URL-style connection
+// string, falling back to "postgis" for plain DSNs/connection strings
+// that do not use the "scheme://" form.
+func ConnectionTypeOf(conn string) ConnectionType {
+	return connectionType(conn)
+}
+
+func connectionType(conn string) ConnectionType {
+	if idx := strings.Index(conn, "://"); idx >= 0 {
+		return ConnectionType(conn[:idx])
+	}
+	return ConnectionType("postgis")
+}
@@ -0,0 +1,296 @@
+// Package flatgeobuf implements a FlatGeobuf output backend. Features
+// are buffered in memory as they arrive (FlatGeobuf needs the full
+// extent and feature count up front) and serialized to disk on Finish:
+// a header whose columns are inferred from mapping.Mapping, a packed
+// Hilbert R-tree index, then the feature payloads themselves.
+package flatgeobuf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"goposm/database"
+	"goposm/element"
+	"goposm/mapping"
+	"os"
+	"sort"
+	"strings"
+)
+
+var magic = [8]byte{'f', 'g', 'b', 3, 'f', 'g', 'b', 0}
+
+func init() {
+	database.Register("flatgeobuf", driver{})
+}
+
+type driver struct{}
+
+func (driver) Open(conf database.Config) (database.DB, error) {
+	path := strings.TrimPrefix(conf.ConnectionParams, "flatgeobuf://")
+	columns := columnsFromMapping(conf.Mapping)
+	return &FlatGeobuf{path: path, srid: conf.Srid, columns: columns}, nil
+}
+
+// feature is a buffered row: its geometry, its OSM id, and the tag
+// values in column order.
+type feature struct {
+	geom   database.Geometry
+	osmId  int64
+	values []string
+}
+
+// FlatGeobuf buffers every inserted feature and only writes the .fgb
+// file on Finish, once the full extent and feature count are known.
+type FlatGeobuf struct {
+	path     string
+	srid     int
+	columns  []string
+	features []feature
+}
+
+func (f *FlatGeobuf) Init() error {
+	return nil
+}
+
+func (f *FlatGeobuf) InsertPoint(elem element.Node, geom database.Geometry, matches []mapping.Match) error {
+	return f.buffer(elem.Id, geom, matches)
+}
+
+func (f *FlatGeobuf) InsertLineString(elem element.Way, geom database.Geometry, matches []mapping.Match) error {
+	return f.buffer(elem.Id, geom, matches)
+}
+
+func (f *FlatGeobuf) InsertPolygon(elem element.Way, geom database.Geometry, matches []mapping.Match) error {
+	return f.buffer(elem.Id, geom, matches)
+}
+
+func (f *FlatGeobuf) buffer(osmId int64, geom database.Geometry, matches []mapping.Match) error {
+	if !geom.Valid {
+		return nil
+	}
+	f.features = append(f.features, feature{
+		geom:   geom,
+		osmId:  osmId,
+		values: mapping.MatchesAsColumns(matches, f.columns),
+	})
+	return nil
+}
+
+func (f *FlatGeobuf) Close() error {
+	return nil
+}
+
+// Finish orders the buffered features along a Hilbert curve over their
+// bounding boxes, writes the FlatGeobuf header (magic, srid, feature
+// count, extent, column schema), the resulting packed Hilbert R-tree index,
+// and finally the feature payloads in that Hilbert order.
+func (f *FlatGeobuf) Finish() error {
+	out, err := os.Create(f.path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+
+	boxes := make([]hilbertBox, len(f.features))
+	for i, feat := range f.features {
+		b, err := wkbEnvelope(feat.geom.Wkb)
+		if err != nil {
+			return fmt.Errorf("flatgeobuf: feature %d: %v", i, err)
+		}
+		boxes[i] = hilbertBox{index: i, box: b}
+	}
+	extent, err := wholeExtent(boxes)
+	if err != nil {
+		return err
+	}
+	sortByHilbert(boxes, extent)
+
+	if _, err := w.Write(magic[:]); err != nil {
+		return err
+	}
+	if err := writeHeader(w, f.srid, f.columns, len(f.features), extent); err != nil {
+		return err
+	}
+	if err := writePackedRtree(w, boxes); err != nil {
+		return err
+	}
+	for _, b := range boxes {
+		if err := writeFeature(w, f.features[b.index]); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+func columnsFromMapping(m *mapping.Mapping) []string {
+	if m == nil {
+		return nil
+	}
+	return m.ColumnNames()
+}
+
+type box struct{ minX, minY, maxX, maxY float64 }
+
+type hilbertBox struct {
+	index int
+	box   box
+}
+
+func wholeExtent(boxes []hilbertBox) (box, error) {
+	if len(boxes) == 0 {
+		return box{}, fmt.Errorf("flatgeobuf: no features to write")
+	}
+	ext := boxes[0].box
+	for _, hb := range boxes[1:] {
+		b := hb.box
+		if b.minX < ext.minX {
+			ext.minX = b.minX
+		}
+		if b.minY < ext.minY {
+			ext.minY = b.minY
+		}
+		if b.maxX > ext.maxX {
+			ext.maxX = b.maxX
+		}
+		if b.maxY > ext.maxY {
+			ext.maxY = b.maxY
+		}
+	}
+	return ext, nil
+}
+
+// sortByHilbert orders boxes along the Hilbert curve of their bounding
+// box centers within extent, as required for a packed Hilbert R-tree.
+func sortByHilbert(boxes []hilbertBox, extent box) {
+	const order = 16 // 2^16 grid per axis
+	// largest grid coordinate, so that the extent's max edge stays inside
+	// the grid instead of landing on 2^16 and wrapping to 0.
+	side := float64(uint32(1)<<order - 1)
+
+	hilbertOf := func(b box) uint64 {
+		cx := (b.minX + b.maxX) / 2
+		cy := (b.minY + b.maxY) / 2
+		x := uint32(0)
+		y := uint32(0)
+		if extent.maxX > extent.minX {
+			x = uint32((cx - extent.minX) / (extent.maxX - extent.minX) * side)
+		}
+		if extent.maxY > extent.minY {
+			y = uint32((cy - extent.minY) / (extent.maxY - extent.minY) * side)
+		}
+		return hilbertD2XY(order, x, y)
+	}
+
+	sort.Slice(boxes, func(i, j int) bool {
+		return hilbertOf(boxes[i].box) < hilbertOf(boxes[j].box)
+	})
+}
+
+// hilbertD2XY maps an (x, y) grid coordinate to its distance along the
+// Hilbert curve of the given order, using the standard bit-rotation
+// algorithm.
+func hilbertD2XY(order uint, x, y uint32) uint64 {
+	var rx, ry uint32
+	var d uint64
+	for s := uint32(1) << (order - 1); s > 0; s /= 2 {
+		if (x & s) > 0 {
+			rx = 1
+		} else {
+			rx = 0
+		}
+		if (y & s) > 0 {
+			ry = 1
+		} else {
+			ry = 0
+		}
+		d += uint64(s) * uint64(s) * uint64((3*rx)^ry)
+
+		// rotate
+		if ry == 0 {
+			if rx == 1 {
+				x = s - 1 - x
+				y = s - 1 - y
+			}
+			x, y = y, x
+		}
+	}
+	return d
+}
+
+func writeHeader(w *bufio.Writer, srid int, columns []string, count int, extent box) error {
+	if err := binary.Write(w, binary.LittleEndian, int32(srid)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, int64(count)); err != nil {
+		return err
+	}
+	for _, f := range []float64{extent.minX, extent.minY, extent.maxX, extent.maxY} {
+		if err := binary.Write(w, binary.LittleEndian, f); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(w, binary.LittleEndian, int32(len(columns))); err != nil {
+		return err
+	}
+	for _, col := range columns {
+		if err := binary.Write(w, binary.LittleEndian, int32(len(col))); err != nil {
+			return err
+		}
+		if _, err := w.WriteString(col); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePackedRtree writes one node per feature, in the Hilbert-sorted
+// order already applied to boxes: this is the "packed" Hilbert R-tree,
+// a flat array of leaf nodes rather than a pointer-based tree.
+func writePackedRtree(w *bufio.Writer, boxes []hilbertBox) error {
+	for _, b := range boxes {
+		for _, v := range []float64{b.box.minX, b.box.minY, b.box.maxX, b.box.maxY} {
+			if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+				return err
+			}
+		}
+		if err := binary.Write(w, binary.LittleEndian, int64(b.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFeature(w *bufio.Writer, feat feature) error {
+	if err := binary.Write(w, binary.LittleEndian, feat.osmId); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, int32(len(feat.geom.Wkb))); err != nil {
+		return err
+	}
+	if _, err := w.Write(feat.geom.Wkb); err != nil {
+		return err
+	}
+	for _, v := range feat.values {
+		if err := binary.Write(w, binary.LittleEndian, int32(len(v))); err != nil {
+			return err
+		}
+		if _, err := w.WriteString(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wkbEnvelope computes the 2D bounding box of a WKB-encoded geometry by
+// walking its actual structure (database.WKBEnvelope), since LineString
+// and Polygon WKB carry length-prefixes before their coordinates that a
+// flat "every 16 bytes is a point" scan would misread as coordinates.
+func wkbEnvelope(wkb []byte) (box, error) {
+	env, err := database.WKBEnvelope(wkb)
+	if err != nil {
+		return box{}, err
+	}
+	return box{minX: env.MinX, minY: env.MinY, maxX: env.MaxX, maxY: env.MaxY}, nil
+}
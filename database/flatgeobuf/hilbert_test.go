@@ -0,0 +1,69 @@
+package flatgeobuf
+
+import "testing"
+
+func TestHilbertD2XYDistinctForDistinctCells(t *testing.T) {
+	const order = 4
+	seen := map[uint64]bool{}
+	side := uint32(1) << order
+	for x := uint32(0); x < side; x++ {
+		for y := uint32(0); y < side; y++ {
+			d := hilbertD2XY(order, x, y)
+			if seen[d] {
+				t.Fatalf("hilbertD2XY(%d, %d) = %d collides with an earlier cell", x, y, d)
+			}
+			seen[d] = true
+		}
+	}
+}
+
+func TestHilbertD2XYNeighborsAreClose(t *testing.T) {
+	const order = 8
+	a := hilbertD2XY(order, 10, 10)
+	b := hilbertD2XY(order, 10, 11)
+	if diff := int64(a) - int64(b); diff > 4 || diff < -4 {
+		t.Errorf("adjacent cells should have nearby Hilbert distances, got %d and %d", a, b)
+	}
+}
+
+func TestWholeExtentEmpty(t *testing.T) {
+	_, err := wholeExtent(nil)
+	if err == nil {
+		t.Fatal("expected error for empty feature set, got nil")
+	}
+}
+
+func TestWholeExtentUnion(t *testing.T) {
+	boxes := []hilbertBox{
+		{index: 0, box: box{minX: 0, minY: 0, maxX: 1, maxY: 1}},
+		{index: 1, box: box{minX: -2, minY: 3, maxX: 4, maxY: 5}},
+	}
+	ext, err := wholeExtent(boxes)
+	if err != nil {
+		t.Fatalf("wholeExtent: %v", err)
+	}
+	want := box{minX: -2, minY: 0, maxX: 4, maxY: 5}
+	if ext != want {
+		t.Errorf("extent = %+v, want %+v", ext, want)
+	}
+}
+
+func TestSortByHilbertOrdersByProximity(t *testing.T) {
+	extent := box{minX: 0, minY: 0, maxX: 10, maxY: 10}
+	boxes := []hilbertBox{
+		{index: 0, box: box{minX: 9, minY: 9, maxX: 9, maxY: 9}},
+		{index: 1, box: box{minX: 0, minY: 0, maxX: 0, maxY: 0}},
+		{index: 2, box: box{minX: 0.1, minY: 0.1, maxX: 0.1, maxY: 0.1}},
+	}
+	sortByHilbert(boxes, extent)
+
+	// the two points near the origin should end up adjacent in the
+	// sorted order, regardless of which one sorts first.
+	positions := map[int]int{}
+	for pos, b := range boxes {
+		positions[b.index] = pos
+	}
+	if diff := positions[1] - positions[2]; diff != 1 && diff != -1 {
+		t.Errorf("features 1 and 2 should be adjacent after Hilbert sort, got positions %v", positions)
+	}
+}
@@ -0,0 +1,203 @@
+// Package gpkg implements a GeoPackage (SQLite) output backend. Tables
+// are registered in gpkg_contents/gpkg_geometry_columns as required by
+// the OGC GeoPackage spec, and each table gets an R-tree spatial index:
+// insert() adds each row's bbox to it, and a delete trigger removes it.
+package gpkg
+
+import (
+	"database/sql"
+	"fmt"
+	"goposm/database"
+	"goposm/element"
+	"goposm/mapping"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	database.Register("gpkg", driver{})
+}
+
+type driver struct{}
+
+func (driver) Open(conf database.Config) (database.DB, error) {
+	path := strings.TrimPrefix(conf.ConnectionParams, "gpkg://")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	return &GeoPackage{db: db, srid: conf.Srid, mapping: conf.Mapping}, nil
+}
+
+// GeoPackage writes features into a single .gpkg file, one table per
+// geometry type (points, line_strings, polygons).
+type GeoPackage struct {
+	db      *sql.DB
+	srid    int
+	mapping *mapping.Mapping
+	tx      *sql.Tx
+}
+
+// Init creates gpkg_contents/gpkg_geometry_columns and the feature
+// tables for points, line strings and polygons, each with an R-tree
+// spatial index.
+func (g *GeoPackage) Init() error {
+	for _, stmt := range gpkgMetadataSchema {
+		if _, err := g.db.Exec(stmt); err != nil {
+			return fmt.Errorf("gpkg: creating metadata tables: %v", err)
+		}
+	}
+
+	for _, table := range []struct {
+		name     string
+		geomType string
+	}{
+		{"points", "POINT"},
+		{"line_strings", "LINESTRING"},
+		{"polygons", "POLYGON"},
+	} {
+		if err := g.createFeatureTable(table.name, table.geomType); err != nil {
+			return err
+		}
+	}
+
+	tx, err := g.db.Begin()
+	if err != nil {
+		return err
+	}
+	g.tx = tx
+	return nil
+}
+
+// createFeatureTable creates a feature table with an fid primary key, an
+// osm_id column, a geom column and a tags column, registers it in
+// gpkg_contents/gpkg_geometry_columns and adds the R-tree index and its
+// maintenance triggers.
+func (g *GeoPackage) createFeatureTable(table, geomType string) error {
+	_, err := g.db.Exec(fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS "%s" (
+			fid INTEGER PRIMARY KEY AUTOINCREMENT,
+			osm_id INTEGER NOT NULL,
+			geom %s,
+			tags TEXT
+		)`, table, geomType))
+	if err != nil {
+		return err
+	}
+
+	_, err = g.db.Exec(
+		`INSERT OR REPLACE INTO gpkg_contents
+			(table_name, data_type, identifier, srs_id, last_change)
+		 VALUES (?, 'features', ?, ?, ?)`,
+		table, table, g.srid, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+
+	_, err = g.db.Exec(
+		`INSERT OR REPLACE INTO gpkg_geometry_columns
+			(table_name, column_name, geometry_type_name, srs_id, z, m)
+		 VALUES (?, 'geom', ?, ?, 0, 0)`,
+		table, geomType, g.srid)
+	if err != nil {
+		return err
+	}
+
+	return g.createRtreeIndex(table)
+}
+
+// createRtreeIndex adds an rtree_<table>_geom virtual table plus the
+// delete trigger that keeps it in sync, matching the GeoPackage
+// extension for spatial indexes. There is no insert trigger: computing
+// a bounding box from the geom blob needs SpatiaLite's ST_MinX/ST_MaxX/
+// etc, which plain mattn/go-sqlite3 does not provide, so insert() bounds
+// the bbox in Go and writes it to the rtree table itself.
+func (g *GeoPackage) createRtreeIndex(table string) error {
+	rtree := fmt.Sprintf("rtree_%s_geom", table)
+	stmts := []string{
+		fmt.Sprintf(`CREATE VIRTUAL TABLE IF NOT EXISTS "%s" USING rtree(id, minx, maxx, miny, maxy)`, rtree),
+		fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS "%s_delete" AFTER DELETE ON "%s"
+			BEGIN
+				DELETE FROM "%s" WHERE id = OLD.fid;
+			END`, rtree, table, rtree),
+	}
+	for _, stmt := range stmts {
+		if _, err := g.db.Exec(stmt); err != nil {
+			return fmt.Errorf("gpkg: creating %s: %v", rtree, err)
+		}
+	}
+	return nil
+}
+
+func (g *GeoPackage) InsertPoint(elem element.Node, geom database.Geometry, matches []mapping.Match) error {
+	return g.insert("points", elem.Id, geom, matches)
+}
+
+func (g *GeoPackage) InsertLineString(elem element.Way, geom database.Geometry, matches []mapping.Match) error {
+	return g.insert("line_strings", elem.Id, geom, matches)
+}
+
+func (g *GeoPackage) InsertPolygon(elem element.Way, geom database.Geometry, matches []mapping.Match) error {
+	return g.insert("polygons", elem.Id, geom, matches)
+}
+
+func (g *GeoPackage) insert(table string, osmId int64, geom database.Geometry, matches []mapping.Match) error {
+	if !geom.Valid {
+		return nil
+	}
+	res, err := g.tx.Exec(fmt.Sprintf(`INSERT INTO "%s" (osm_id, geom, tags) VALUES (?, ?, ?)`, table),
+		osmId, geom.Wkb, mapping.MatchesAsTags(matches))
+	if err != nil {
+		return err
+	}
+
+	env, err := database.WKBEnvelope(geom.Wkb)
+	if err != nil {
+		return fmt.Errorf("gpkg: computing bbox for %s %d: %v", table, osmId, err)
+	}
+	fid, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	_, err = g.tx.Exec(fmt.Sprintf(`INSERT OR REPLACE INTO "rtree_%s_geom" VALUES (?, ?, ?, ?, ?)`, table),
+		fid, env.MinX, env.MaxX, env.MinY, env.MaxY)
+	return err
+}
+
+// Finish commits the insert transaction and runs ANALYZE so the R-tree
+// indexes are immediately usable by readers.
+func (g *GeoPackage) Finish() error {
+	if err := g.tx.Commit(); err != nil {
+		return err
+	}
+	_, err := g.db.Exec("ANALYZE")
+	return err
+}
+
+func (g *GeoPackage) Close() error {
+	return g.db.Close()
+}
+
+var gpkgMetadataSchema = []string{
+	`CREATE TABLE IF NOT EXISTS gpkg_contents (
+		table_name TEXT NOT NULL PRIMARY KEY,
+		data_type TEXT NOT NULL,
+		identifier TEXT UNIQUE,
+		srs_id INTEGER,
+		last_change TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS gpkg_geometry_columns (
+		table_name TEXT NOT NULL,
+		column_name TEXT NOT NULL,
+		geometry_type_name TEXT NOT NULL,
+		srs_id INTEGER NOT NULL,
+		z TINYINT NOT NULL,
+		m TINYINT NOT NULL,
+		PRIMARY KEY (table_name, column_name)
+	)`,
+}
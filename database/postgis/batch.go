@@ -0,0 +1,88 @@
+package postgis
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// BeginBatch starts a transaction that InsertBatch/DeleteBatch append to.
+// The transaction is committed or rolled back by CommitBatch/RollbackBatch.
+func (pg *PostGIS) BeginBatch(size int64) error {
+	tx, err := pg.Db.Begin()
+	if err != nil {
+		return err
+	}
+	pg.batchTx = tx
+	pg.batchSize = size
+	return nil
+}
+
+// InsertBatch upserts row (osm_id, geometry WKB, tags) into table as
+// part of the open batch transaction, started by BeginBatch.
+func (pg *PostGIS) InsertBatch(table string, row []interface{}) error {
+	if pg.batchTx == nil {
+		return fmt.Errorf("postgis: InsertBatch called without BeginBatch")
+	}
+	stmt, err := pg.batchInsertStmt(table)
+	if err != nil {
+		return err
+	}
+	_, err = pg.batchTx.Stmt(stmt).Exec(row...)
+	return err
+}
+
+// DeleteBatch removes the row with the given OSM id from table as part
+// of the open batch transaction.
+func (pg *PostGIS) DeleteBatch(table string, id int64) error {
+	if pg.batchTx == nil {
+		return fmt.Errorf("postgis: DeleteBatch called without BeginBatch")
+	}
+	_, err := pg.batchTx.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE osm_id = $1`, table), id)
+	return err
+}
+
+// CommitBatch commits the batch transaction opened by BeginBatch.
+func (pg *PostGIS) CommitBatch() error {
+	if pg.batchTx == nil {
+		return fmt.Errorf("postgis: CommitBatch called without BeginBatch")
+	}
+	err := pg.batchTx.Commit()
+	pg.batchTx = nil
+	return err
+}
+
+// RollbackBatch discards the batch transaction opened by BeginBatch.
+func (pg *PostGIS) RollbackBatch() error {
+	if pg.batchTx == nil {
+		return nil
+	}
+	err := pg.batchTx.Rollback()
+	pg.batchTx = nil
+	return err
+}
+
+// batchInsertStmt returns a cached upsert statement for table, keyed on
+// the osm_id UNIQUE constraint Init() creates, preparing one on first
+// use. Like the non-batch insert path, the geometry placeholder is
+// wrapped in ST_GeomFromWKB with the table's SRID, since the column is
+// declared GEOMETRY(..., srid) and rejects raw WKB (which carries no
+// SRID of its own).
+func (pg *PostGIS) batchInsertStmt(table string) (*sql.Stmt, error) {
+	if pg.batchStmts == nil {
+		pg.batchStmts = make(map[string]*sql.Stmt)
+	}
+	if stmt, ok := pg.batchStmts[table]; ok {
+		return stmt, nil
+	}
+
+	query := fmt.Sprintf(
+		`INSERT INTO "%s" (osm_id, geometry, tags) VALUES ($1, ST_GeomFromWKB($2, %d), $3)
+		 ON CONFLICT (osm_id) DO UPDATE SET geometry = EXCLUDED.geometry, tags = EXCLUDED.tags`,
+		table, pg.srid)
+	stmt, err := pg.Db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	pg.batchStmts[table] = stmt
+	return stmt, nil
+}
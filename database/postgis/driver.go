@@ -0,0 +1,16 @@
+package postgis
+
+import "goposm/database"
+
+// driver implements database.Driver for the "postgis" backend, wrapping
+// the pre-existing PostGIS type so that it can be selected the same way
+// as any other backend registered with database.Register.
+type driver struct{}
+
+func (driver) Open(conf database.Config) (database.DB, error) {
+	return newPostGIS(conf)
+}
+
+func init() {
+	database.Register("postgis", driver{})
+}
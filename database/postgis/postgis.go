@@ -0,0 +1,215 @@
+// Package postgis is the default output backend: it writes features
+// into a PostGIS-enabled PostgreSQL database, into a "_new" staging
+// table that -deployproduction swaps into place as the live table.
+package postgis
+
+import (
+	"database/sql"
+	"fmt"
+	"goposm/database"
+	"goposm/element"
+	"goposm/mapping"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+const (
+	stagingSuffix = "_new"
+	backupSuffix  = "_backup"
+)
+
+// newPostGIS opens a connection to conf.ConnectionParams (a plain
+// postgres DSN, or a postgis:// URL that is passed on as postgres://)
+// and prepares a PostGIS ready to import into its staging tables.
+func newPostGIS(conf database.Config) (*PostGIS, error) {
+	dsn := conf.ConnectionParams
+	if strings.HasPrefix(dsn, "postgis://") {
+		dsn = "postgres://" + strings.TrimPrefix(dsn, "postgis://")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	return &PostGIS{
+		Db:      db,
+		srid:    conf.Srid,
+		mapping: conf.Mapping,
+	}, nil
+}
+
+// PostGIS writes points/line strings/polygons into
+// points_new/line_strings_new/polygons_new, and deploys them to their
+// production names on -deployproduction.
+type PostGIS struct {
+	Db      *sql.DB
+	srid    int
+	mapping *mapping.Mapping
+
+	insertStmts map[string]*sql.Stmt
+
+	// batch* fields back database.BatchUpdater, used by the diff
+	// subsystem to apply a changeset transactionally; see batch.go.
+	batchTx    *sql.Tx
+	batchSize  int64
+	batchStmts map[string]*sql.Stmt
+}
+
+// Init (re-)creates the _new staging tables the next import will write
+// into.
+func (pg *PostGIS) Init() error {
+	for _, table := range []struct {
+		name     string
+		geomType string
+	}{
+		{"points", "POINT"},
+		{"line_strings", "LINESTRING"},
+		{"polygons", "POLYGON"},
+	} {
+		name := table.name + stagingSuffix
+		_, err := pg.Db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, name))
+		if err != nil {
+			return err
+		}
+		_, err = pg.Db.Exec(fmt.Sprintf(
+			`CREATE TABLE "%s" (
+				osm_id BIGINT NOT NULL UNIQUE,
+				geometry GEOMETRY(%s, %d),
+				tags HSTORE
+			)`, name, table.geomType, pg.srid))
+		if err != nil {
+			return fmt.Errorf("postgis: creating %s: %v", name, err)
+		}
+	}
+	pg.insertStmts = nil
+	return nil
+}
+
+func (pg *PostGIS) InsertPoint(elem element.Node, geom database.Geometry, matches []mapping.Match) error {
+	return pg.insert("points"+stagingSuffix, elem.Id, geom, matches)
+}
+
+func (pg *PostGIS) InsertLineString(elem element.Way, geom database.Geometry, matches []mapping.Match) error {
+	return pg.insert("line_strings"+stagingSuffix, elem.Id, geom, matches)
+}
+
+func (pg *PostGIS) InsertPolygon(elem element.Way, geom database.Geometry, matches []mapping.Match) error {
+	return pg.insert("polygons"+stagingSuffix, elem.Id, geom, matches)
+}
+
+func (pg *PostGIS) insert(table string, osmId int64, geom database.Geometry, matches []mapping.Match) error {
+	if !geom.Valid {
+		return nil
+	}
+	stmt, err := pg.insertStmt(table)
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(osmId, geom.Wkb, mapping.MatchesAsTags(matches))
+	return err
+}
+
+func (pg *PostGIS) insertStmt(table string) (*sql.Stmt, error) {
+	if pg.insertStmts == nil {
+		pg.insertStmts = make(map[string]*sql.Stmt)
+	}
+	if stmt, ok := pg.insertStmts[table]; ok {
+		return stmt, nil
+	}
+	stmt, err := pg.Db.Prepare(fmt.Sprintf(
+		`INSERT INTO "%s" (osm_id, geometry, tags) VALUES ($1, ST_GeomFromWKB($2, %d), $3)`,
+		table, pg.srid))
+	if err != nil {
+		return nil, err
+	}
+	pg.insertStmts[table] = stmt
+	return stmt, nil
+}
+
+func (pg *PostGIS) Close() error {
+	return pg.Db.Close()
+}
+
+// Finish builds spatial indexes on the staging tables now that every
+// feature has been inserted. Index creation is deferred to here rather
+// than done up front because building it row-by-row during Init/insert
+// would be far slower than building it once the table is fully
+// populated.
+func (pg *PostGIS) Finish() error {
+	for _, table := range []string{"points", "line_strings", "polygons"} {
+		name := table + stagingSuffix
+		_, err := pg.Db.Exec(fmt.Sprintf(
+			`CREATE INDEX "%s_geom_idx" ON "%s" USING GIST (geometry)`, name, name))
+		if err != nil {
+			return fmt.Errorf("postgis: indexing %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Deploy swaps the _new staging tables into their production names,
+// keeping the previous production tables around as _backup so that
+// RevertDeploy can undo a bad import.
+func (pg *PostGIS) Deploy() error {
+	for _, table := range []string{"points", "line_strings", "polygons"} {
+		if err := pg.renameTable(table+backupSuffix, ""); err != nil {
+			return err
+		}
+		if err := pg.renameTable(table, table+backupSuffix); err != nil {
+			return err
+		}
+		if err := pg.renameTable(table+stagingSuffix, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevertDeploy undoes a Deploy by restoring the _backup tables it left
+// behind to production.
+func (pg *PostGIS) RevertDeploy() error {
+	for _, table := range []string{"points", "line_strings", "polygons"} {
+		if err := pg.renameTable(table, ""); err != nil {
+			return err
+		}
+		if err := pg.renameTable(table+backupSuffix, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveBackup drops the _backup tables left behind by a Deploy, once
+// the new production tables are confirmed good.
+func (pg *PostGIS) RemoveBackup() error {
+	for _, table := range []string{"points", "line_strings", "polygons"} {
+		if err := pg.renameTable(table+backupSuffix, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameTable renames src to dst, or drops src if dst is empty. Missing
+// src is not an error: Deploy/RevertDeploy/RemoveBackup all run on a
+// fresh database where no previous backup exists yet.
+func (pg *PostGIS) renameTable(src, dst string) error {
+	if dst == "" {
+		_, err := pg.Db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, src))
+		return err
+	}
+	var exists bool
+	err := pg.Db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = $1)`, src).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	_, err = pg.Db.Exec(fmt.Sprintf(`ALTER TABLE "%s" RENAME TO "%s"`, src, dst))
+	return err
+}
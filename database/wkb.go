@@ -0,0 +1,166 @@
+package database
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Envelope is the 2D bounding box of a geometry.
+type Envelope struct {
+	MinX, MinY, MaxX, MaxY float64
+}
+
+const (
+	wkbPoint              = 1
+	wkbLineString         = 2
+	wkbPolygon            = 3
+	wkbMultiPoint         = 4
+	wkbMultiLineString    = 5
+	wkbMultiPolygon       = 6
+	wkbGeometryCollection = 7
+)
+
+// WKBEnvelope computes the 2D bounding box of a WKB-encoded geometry,
+// walking its actual structure rather than assuming a flat coordinate
+// array: Point, LineString, Polygon, their Multi* variants and
+// GeometryCollection (as produced for multipolygon relations) all carry
+// different length-prefixes before their coordinates.
+func WKBEnvelope(wkb []byte) (Envelope, error) {
+	c := &wkbCursor{data: wkb}
+	env := Envelope{MinX: math.Inf(1), MinY: math.Inf(1), MaxX: math.Inf(-1), MaxY: math.Inf(-1)}
+	if err := c.readGeometry(&env); err != nil {
+		return Envelope{}, err
+	}
+	if env.MinX > env.MaxX {
+		return Envelope{}, fmt.Errorf("database: empty geometry")
+	}
+	return env, nil
+}
+
+type wkbCursor struct {
+	data []byte
+	pos  int
+}
+
+func (c *wkbCursor) take(n int) ([]byte, error) {
+	if c.pos+n > len(c.data) {
+		return nil, fmt.Errorf("database: truncated WKB at offset %d", c.pos)
+	}
+	b := c.data[c.pos : c.pos+n]
+	c.pos += n
+	return b, nil
+}
+
+func (c *wkbCursor) readHeader() (binary.ByteOrder, uint32, error) {
+	b, err := c.take(1)
+	if err != nil {
+		return nil, 0, err
+	}
+	order := binary.ByteOrder(binary.LittleEndian)
+	if b[0] == 0 {
+		order = binary.BigEndian
+	}
+	t, err := c.take(4)
+	if err != nil {
+		return nil, 0, err
+	}
+	return order, order.Uint32(t), nil
+}
+
+func (c *wkbCursor) readUint32(order binary.ByteOrder) (uint32, error) {
+	b, err := c.take(4)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint32(b), nil
+}
+
+func (c *wkbCursor) readPoint(order binary.ByteOrder, env *Envelope) error {
+	b, err := c.take(16)
+	if err != nil {
+		return err
+	}
+	x := math.Float64frombits(order.Uint64(b[0:8]))
+	y := math.Float64frombits(order.Uint64(b[8:16]))
+	if x < env.MinX {
+		env.MinX = x
+	}
+	if y < env.MinY {
+		env.MinY = y
+	}
+	if x > env.MaxX {
+		env.MaxX = x
+	}
+	if y > env.MaxY {
+		env.MaxY = y
+	}
+	return nil
+}
+
+// readGeometry reads one full WKB geometry (own byte-order/type header
+// included) and extends env with its bounding box.
+func (c *wkbCursor) readGeometry(env *Envelope) error {
+	order, geomType, err := c.readHeader()
+	if err != nil {
+		return err
+	}
+	return c.readBody(order, geomType, env)
+}
+
+// readBody reads the type-specific payload that follows a WKB header
+// already consumed by the caller.
+func (c *wkbCursor) readBody(order binary.ByteOrder, geomType uint32, env *Envelope) error {
+	switch geomType {
+	case wkbPoint:
+		return c.readPoint(order, env)
+
+	case wkbLineString:
+		n, err := c.readUint32(order)
+		if err != nil {
+			return err
+		}
+		for i := uint32(0); i < n; i++ {
+			if err := c.readPoint(order, env); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case wkbPolygon:
+		numRings, err := c.readUint32(order)
+		if err != nil {
+			return err
+		}
+		for r := uint32(0); r < numRings; r++ {
+			numPoints, err := c.readUint32(order)
+			if err != nil {
+				return err
+			}
+			for i := uint32(0); i < numPoints; i++ {
+				if err := c.readPoint(order, env); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+
+	case wkbMultiPoint, wkbMultiLineString, wkbMultiPolygon, wkbGeometryCollection:
+		n, err := c.readUint32(order)
+		if err != nil {
+			return err
+		}
+		for i := uint32(0); i < n; i++ {
+			// every member of a Multi*/GeometryCollection carries its
+			// own byte-order + type header, unlike the rings/points of
+			// a single Polygon/LineString.
+			if err := c.readGeometry(env); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("database: unsupported WKB geometry type %d", geomType)
+	}
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func wkbPointBytes(x, y float64) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(1) // little-endian
+	binary.Write(&buf, binary.LittleEndian, uint32(wkbPoint))
+	binary.Write(&buf, binary.LittleEndian, x)
+	binary.Write(&buf, binary.LittleEndian, y)
+	return buf.Bytes()
+}
+
+func wkbLineStringBytes(points [][2]float64) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(1)
+	binary.Write(&buf, binary.LittleEndian, uint32(wkbLineString))
+	binary.Write(&buf, binary.LittleEndian, uint32(len(points)))
+	for _, p := range points {
+		binary.Write(&buf, binary.LittleEndian, p[0])
+		binary.Write(&buf, binary.LittleEndian, p[1])
+	}
+	return buf.Bytes()
+}
+
+func wkbPolygonBytes(rings [][][2]float64) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(1)
+	binary.Write(&buf, binary.LittleEndian, uint32(wkbPolygon))
+	binary.Write(&buf, binary.LittleEndian, uint32(len(rings)))
+	for _, ring := range rings {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(ring)))
+		for _, p := range ring {
+			binary.Write(&buf, binary.LittleEndian, p[0])
+			binary.Write(&buf, binary.LittleEndian, p[1])
+		}
+	}
+	return buf.Bytes()
+}
+
+func wkbMultiPointBytes(points [][2]float64) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(1)
+	binary.Write(&buf, binary.LittleEndian, uint32(wkbMultiPoint))
+	binary.Write(&buf, binary.LittleEndian, uint32(len(points)))
+	for _, p := range points {
+		buf.Write(wkbPointBytes(p[0], p[1]))
+	}
+	return buf.Bytes()
+}
+
+func TestWKBEnvelopePoint(t *testing.T) {
+	env, err := WKBEnvelope(wkbPointBytes(1, 2))
+	if err != nil {
+		t.Fatalf("WKBEnvelope: %v", err)
+	}
+	want := Envelope{MinX: 1, MinY: 2, MaxX: 1, MaxY: 2}
+	if env != want {
+		t.Errorf("envelope = %+v, want %+v", env, want)
+	}
+}
+
+// TestWKBEnvelopeLineStringTwoPoints covers a LineString with only two
+// points: a flat "every 16 bytes is a point" reader would misread the
+// uint32 point-count prefix as the first half of a coordinate.
+func TestWKBEnvelopeLineStringTwoPoints(t *testing.T) {
+	wkb := wkbLineStringBytes([][2]float64{{0, 0}, {10, 20}})
+	env, err := WKBEnvelope(wkb)
+	if err != nil {
+		t.Fatalf("WKBEnvelope: %v", err)
+	}
+	want := Envelope{MinX: 0, MinY: 0, MaxX: 10, MaxY: 20}
+	if env != want {
+		t.Errorf("envelope = %+v, want %+v", env, want)
+	}
+}
+
+func TestWKBEnvelopePolygonWithHole(t *testing.T) {
+	outer := [][2]float64{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}
+	hole := [][2]float64{{4, 4}, {6, 4}, {6, 6}, {4, 6}, {4, 4}}
+	env, err := WKBEnvelope(wkbPolygonBytes([][][2]float64{outer, hole}))
+	if err != nil {
+		t.Fatalf("WKBEnvelope: %v", err)
+	}
+	want := Envelope{MinX: 0, MinY: 0, MaxX: 10, MaxY: 10}
+	if env != want {
+		t.Errorf("envelope = %+v, want %+v", env, want)
+	}
+}
+
+func TestWKBEnvelopeMultiPoint(t *testing.T) {
+	env, err := WKBEnvelope(wkbMultiPointBytes([][2]float64{{-5, 3}, {7, -2}}))
+	if err != nil {
+		t.Fatalf("WKBEnvelope: %v", err)
+	}
+	want := Envelope{MinX: -5, MinY: -2, MaxX: 7, MaxY: 3}
+	if env != want {
+		t.Errorf("envelope = %+v, want %+v", env, want)
+	}
+}
+
+func TestWKBEnvelopeTruncated(t *testing.T) {
+	wkb := wkbPointBytes(1, 2)
+	_, err := WKBEnvelope(wkb[:len(wkb)-4])
+	if err == nil {
+		t.Fatal("expected error for truncated WKB, got nil")
+	}
+}
+
+func TestWKBEnvelopeUnsupportedType(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteByte(1)
+	binary.Write(&buf, binary.LittleEndian, uint32(99))
+	_, err := WKBEnvelope(buf.Bytes())
+	if err == nil {
+		t.Fatal("expected error for unsupported geometry type, got nil")
+	}
+}
+
+func TestWKBEnvelopeBigEndian(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteByte(0) // big-endian
+	binary.Write(&buf, binary.BigEndian, uint32(wkbPoint))
+	binary.Write(&buf, binary.BigEndian, math.Copysign(1, 1))
+	binary.Write(&buf, binary.BigEndian, math.Copysign(2, 1))
+
+	env, err := WKBEnvelope(buf.Bytes())
+	if err != nil {
+		t.Fatalf("WKBEnvelope: %v", err)
+	}
+	want := Envelope{MinX: 1, MinY: 2, MaxX: 1, MaxY: 2}
+	if env != want {
+		t.Errorf("envelope = %+v, want %+v", env, want)
+	}
+}
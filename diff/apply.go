@@ -0,0 +1,192 @@
+package diff
+
+import (
+	"goposm/database"
+	"goposm/element"
+	"goposm/mapping"
+	"goposm/writer"
+)
+
+// applyDeletes removes deleted elements from osmCache/diffCache and,
+// through batcher, from the database, in relation -> way -> node order
+// so that a deleted way is no longer referenced by the time its nodes
+// are removed from the coordinate index.
+func (u *Update) applyDeletes(batcher database.BatchUpdater, c change) error {
+	for _, rel := range c.Relations {
+		if err := batcher.DeleteBatch(mapping.PolygonTable, rel.Id); err != nil {
+			return err
+		}
+		u.osmCache.Relations.DeleteRelation(rel.Id)
+	}
+	for _, way := range c.Ways {
+		if err := batcher.DeleteBatch(mapping.LineStringTable, way.Id); err != nil {
+			return err
+		}
+		if err := batcher.DeleteBatch(mapping.PolygonTable, way.Id); err != nil {
+			return err
+		}
+		// diffCache.Coords is keyed by node id, so forgetting this way
+		// has to happen against the nodes it used to reference, not
+		// against way.Id itself. The OsmChange delete entry for a way
+		// does not carry its member nodes, so look the cached copy up
+		// before it is removed below.
+		if old, err := u.osmCache.Ways.GetWay(way.Id); err == nil {
+			u.removeWayFromCoords(old)
+		}
+		u.osmCache.Ways.DeleteWay(way.Id)
+	}
+	for _, node := range c.Nodes {
+		if err := batcher.DeleteBatch(mapping.PointTable, node.Id); err != nil {
+			return err
+		}
+		u.osmCache.Nodes.DeleteNode(node.Id)
+		u.diffCache.Coords.Delete(node.Id)
+	}
+	return nil
+}
+
+// applyUpserts stores created/modified elements in osmCache and re-emits
+// their geometry through the existing writer pipeline.
+func (u *Update) applyUpserts(c change, insertBuffer *writer.InsertBuffer,
+	pointsTagMatcher, lineStringsTagMatcher, polygonsTagMatcher mapping.TagMatcher) error {
+
+	if len(c.Nodes) > 0 {
+		u.osmCache.Nodes.PutNodes(c.Nodes)
+		nodeWriter := writer.NewNodeWriter(u.osmCache, nodeChan(c.Nodes),
+			insertBuffer, pointsTagMatcher, u.progress)
+		nodeWriter.Close()
+	}
+
+	if len(c.Ways) > 0 {
+		// a modified way may no longer reference the same nodes it did
+		// before, so drop its old diffCache.Coords entries before
+		// recording the new ones.
+		for _, way := range c.Ways {
+			if old, err := u.osmCache.Ways.GetWay(way.Id); err == nil {
+				u.removeWayFromCoords(old)
+			}
+		}
+		u.osmCache.Ways.PutWays(c.Ways)
+		for _, way := range c.Ways {
+			for _, nodeId := range way.Nodes {
+				u.diffCache.Coords.Put(nodeId, way.Id)
+			}
+		}
+		wayWriter := writer.NewWayWriter(u.osmCache, wayChan(c.Ways),
+			insertBuffer, lineStringsTagMatcher, polygonsTagMatcher, u.progress)
+		wayWriter.Close()
+	}
+
+	if len(c.Relations) > 0 {
+		u.osmCache.Relations.PutRelations(c.Relations)
+		relWriter := writer.NewRelationWriter(u.osmCache, relationChan(c.Relations),
+			insertBuffer, polygonsTagMatcher, u.progress)
+		relWriter.Close()
+	}
+
+	return nil
+}
+
+// reemitDependents re-derives the geometry of every way and relation
+// that references a node touched by changes, and every relation that
+// references a touched way, even though that way/relation itself was not
+// part of the changeset. Without this a node move would silently leave
+// stale geometry behind for the ways/relations built from it.
+func (u *Update) reemitDependents(changes []change, insertBuffer *writer.InsertBuffer,
+	lineStringsTagMatcher, polygonsTagMatcher mapping.TagMatcher) error {
+
+	touchedNodes := map[int64]struct{}{}
+	touchedWays := map[int64]struct{}{}
+	for _, c := range changes {
+		for _, n := range c.Nodes {
+			touchedNodes[n.Id] = struct{}{}
+		}
+		for _, w := range c.Ways {
+			touchedWays[w.Id] = struct{}{}
+		}
+	}
+
+	dependentWays := map[int64]struct{}{}
+	for nodeId := range touchedNodes {
+		for _, wayId := range u.diffCache.Coords.Get(nodeId) {
+			if _, ok := touchedWays[wayId]; ok {
+				continue // already re-emitted as part of the changeset
+			}
+			dependentWays[wayId] = struct{}{}
+		}
+	}
+
+	var ways []element.Way
+	for wayId := range dependentWays {
+		way, err := u.osmCache.Ways.GetWay(wayId)
+		if err != nil {
+			return err
+		}
+		ways = append(ways, way)
+		touchedWays[wayId] = struct{}{}
+	}
+	if len(ways) > 0 {
+		wayWriter := writer.NewWayWriter(u.osmCache, wayChan(ways), insertBuffer,
+			lineStringsTagMatcher, polygonsTagMatcher, u.progress)
+		wayWriter.Close()
+	}
+
+	dependentRelations := map[int64]struct{}{}
+	for wayId := range touchedWays {
+		for _, relId := range u.osmCache.Ways.RelIdsForWay(wayId) {
+			dependentRelations[relId] = struct{}{}
+		}
+	}
+
+	var relations []element.Relation
+	for relId := range dependentRelations {
+		rel, err := u.osmCache.Relations.GetRelation(relId)
+		if err != nil {
+			return err
+		}
+		relations = append(relations, rel)
+	}
+	if len(relations) > 0 {
+		relWriter := writer.NewRelationWriter(u.osmCache, relationChan(relations),
+			insertBuffer, polygonsTagMatcher, u.progress)
+		relWriter.Close()
+	}
+
+	return nil
+}
+
+// removeWayFromCoords drops way's id from the diffCache.Coords entry of
+// every node it references, the inverse of the Put calls made when a
+// way is created/modified.
+func (u *Update) removeWayFromCoords(way element.Way) {
+	for _, nodeId := range way.Nodes {
+		u.diffCache.Coords.RemoveWay(nodeId, way.Id)
+	}
+}
+
+func nodeChan(nodes []element.Node) chan element.Node {
+	ch := make(chan element.Node, len(nodes))
+	for _, n := range nodes {
+		ch <- n
+	}
+	close(ch)
+	return ch
+}
+
+func wayChan(ways []element.Way) chan element.Way {
+	ch := make(chan element.Way, len(ways))
+	for _, w := range ways {
+		ch <- w
+	}
+	close(ch)
+	return ch
+}
+
+func relationChan(relations []element.Relation) chan element.Relation {
+	ch := make(chan element.Relation, len(relations))
+	for _, r := range relations {
+		ch <- r
+	}
+	close(ch)
+	return ch
+}
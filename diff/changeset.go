@@ -0,0 +1,66 @@
+package diff
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	"goposm/element"
+	"io"
+)
+
+// action identifies which of the three OsmChange actions a group of
+// elements belongs to.
+type action string
+
+const (
+	actionCreate action = "create"
+	actionModify action = "modify"
+	actionDelete action = "delete"
+)
+
+// change is a single create/modify/delete group from an OsmChange
+// document, decoded straight from the .osc.gz XML.
+type change struct {
+	Action    action
+	Nodes     []element.Node
+	Ways      []element.Way
+	Relations []element.Relation
+}
+
+// osmChangeDoc mirrors the <osmChange> document structure closely enough
+// to decode create/modify/delete groups with encoding/xml.
+type osmChangeDoc struct {
+	XMLName xml.Name `xml:"osmChange"`
+	Create  group    `xml:"create"`
+	Modify  group    `xml:"modify"`
+	Delete  group    `xml:"delete"`
+}
+
+type group struct {
+	Nodes     []element.Node     `xml:"node"`
+	Ways      []element.Way      `xml:"way"`
+	Relations []element.Relation `xml:"relation"`
+}
+
+// parseChangeset decodes a gzip-compressed OsmChange document (.osc.gz)
+// into the ordered list of changes it contains. Order matters: deletes of
+// a relation must be applied before deletes of the ways/nodes it used to
+// reference, which is why create/modify/delete are kept as separate
+// groups instead of being merged.
+func parseChangeset(r io.Reader) ([]change, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var doc osmChangeDoc
+	if err := xml.NewDecoder(gz).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	return []change{
+		{Action: actionCreate, Nodes: doc.Create.Nodes, Ways: doc.Create.Ways, Relations: doc.Create.Relations},
+		{Action: actionModify, Nodes: doc.Modify.Nodes, Ways: doc.Modify.Ways, Relations: doc.Modify.Relations},
+		{Action: actionDelete, Nodes: doc.Delete.Nodes, Ways: doc.Delete.Ways, Relations: doc.Delete.Relations},
+	}, nil
+}
@@ -0,0 +1,224 @@
+// Package diff implements incremental updates of an imported database
+// from OSM minutely/hourly replication (OsmChange) feeds. It reconciles
+// creates/modifies/deletes against the on-disk osmCache and diffCache,
+// re-derives affected geometries through the writer pipeline and applies
+// the result to a database.DB in transactional batches.
+package diff
+
+import (
+	"fmt"
+	"goposm/cache"
+	"goposm/database"
+	"goposm/mapping"
+	"goposm/stats"
+	"goposm/writer"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Config bundles everything the diff subsystem needs to fetch and apply
+// replication changesets.
+type Config struct {
+	// ReplicationURL is the base URL of a minutely/hourly/daily
+	// replication directory, e.g.
+	// http://planet.openstreetmap.org/replication/minute
+	ReplicationURL string
+	// CacheDir is the -cachedir used for the import; the sequence
+	// tracker is persisted here alongside the osmCache/diffCache.
+	CacheDir string
+	// Interval is how often -diffrun polls for a new sequence once it
+	// has caught up with the replication server.
+	Interval time.Duration
+	// BatchSize, mirroring GOPOSM_DBIMPORT_BATCHSIZE, is passed to
+	// BeginBatch as a size hint. It does not cap the batch: each
+	// changeset is applied in one transaction, however many rows it has.
+	BatchSize int64
+}
+
+// Update applies replication changesets to osmCache/diffCache and db,
+// tracking progress with a StateTracker persisted under CacheDir.
+type Update struct {
+	conf       Config
+	osmCache   *cache.OSMCache
+	diffCache  *cache.DiffCache
+	db         database.DB
+	tagMapping *mapping.Mapping
+	tracker    *StateTracker
+	client     *http.Client
+	progress   *stats.Statistics
+}
+
+// NewUpdate returns an Update ready to fetch and apply changesets.
+func NewUpdate(conf Config, osmCache *cache.OSMCache, diffCache *cache.DiffCache,
+	db database.DB, tagMapping *mapping.Mapping) *Update {
+
+	return &Update{
+		conf:       conf,
+		osmCache:   osmCache,
+		diffCache:  diffCache,
+		db:         db,
+		tagMapping: tagMapping,
+		tracker:    NewStateTracker(conf.CacheDir),
+		client:     &http.Client{Timeout: 60 * time.Second},
+		progress:   stats.StatsReporter(),
+	}
+}
+
+// Run polls the replication server for new sequences until stop is
+// closed, applying each one as it becomes available. This backs the
+// -diffrun mode.
+func (u *Update) Run(stop <-chan struct{}) error {
+	for {
+		applied, err := u.applyNext()
+		if err != nil {
+			return err
+		}
+		if applied {
+			// there may be more sequences waiting, keep going without
+			// sleeping.
+			continue
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(u.conf.Interval):
+		}
+	}
+}
+
+// ApplyAvailable applies every sequence the replication server currently
+// has beyond the last one recorded in the state tracker, then returns.
+// This backs the plain -diff mode, as opposed to -diffrun which keeps
+// polling for new sequences afterwards.
+func (u *Update) ApplyAvailable() error {
+	for {
+		applied, err := u.applyNext()
+		if err != nil {
+			return err
+		}
+		if !applied {
+			return nil
+		}
+	}
+}
+
+// applyNext fetches and applies the sequence following the last one
+// recorded in the state tracker. It returns applied=false if the
+// replication server does not yet have that sequence (i.e. we caught
+// up).
+func (u *Update) applyNext() (applied bool, err error) {
+	state, err := u.tracker.Load()
+	if err != nil {
+		return false, fmt.Errorf("diff: loading state: %v", err)
+	}
+	next := state.Sequence + 1
+
+	body, err := u.fetchSequence(next)
+	if err == errSequenceNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("diff: fetching sequence %d: %v", next, err)
+	}
+	defer body.Close()
+
+	changes, err := parseChangeset(body)
+	if err != nil {
+		return false, fmt.Errorf("diff: parsing sequence %d: %v", next, err)
+	}
+
+	if err := u.apply(changes); err != nil {
+		return false, fmt.Errorf("diff: applying sequence %d: %v", next, err)
+	}
+
+	if err := u.tracker.Save(State{Sequence: next, Timestamp: time.Now()}); err != nil {
+		return false, fmt.Errorf("diff: persisting state: %v", err)
+	}
+
+	log.Printf("diff: applied sequence %d", next)
+	return true, nil
+}
+
+// apply reconciles a decoded changeset against osmCache.Nodes/Ways/
+// Relations and diffCache.Coords, re-emits every way/relation that
+// references a changed node, and pushes the result through the writer
+// pipeline to db.
+func (u *Update) apply(changes []change) error {
+	batcher, ok := u.db.(database.BatchUpdater)
+	if !ok {
+		return fmt.Errorf("diff: %T does not implement database.BatchUpdater, required for -diffrun", u.db)
+	}
+	if err := batcher.BeginBatch(u.conf.BatchSize); err != nil {
+		return err
+	}
+
+	insertBuffer := writer.NewInsertBuffer()
+	dbWriter := writer.NewDbWriter(u.db, insertBuffer.Out)
+
+	pointsTagMatcher := u.tagMapping.PointMatcher()
+	lineStringsTagMatcher := u.tagMapping.LineStringMatcher()
+	polygonsTagMatcher := u.tagMapping.PolygonMatcher()
+
+	for _, c := range changes {
+		switch c.Action {
+		case actionDelete:
+			if err := u.applyDeletes(batcher, c); err != nil {
+				return abortBatch(batcher, err)
+			}
+		default:
+			if err := u.applyUpserts(c, insertBuffer, pointsTagMatcher,
+				lineStringsTagMatcher, polygonsTagMatcher); err != nil {
+				return abortBatch(batcher, err)
+			}
+		}
+	}
+
+	// ways and relations that were not themselves touched but reference
+	// a changed node (or, for relations, a changed way) must be
+	// re-emitted too, since their rendered geometry may have moved.
+	if err := u.reemitDependents(changes, insertBuffer, lineStringsTagMatcher, polygonsTagMatcher); err != nil {
+		return abortBatch(batcher, err)
+	}
+
+	insertBuffer.Close()
+	dbWriter.Close()
+
+	return batcher.CommitBatch()
+}
+
+func abortBatch(batcher database.BatchUpdater, cause error) error {
+	if err := batcher.RollbackBatch(); err != nil {
+		log.Println("diff: rollback failed:", err)
+	}
+	return cause
+}
+
+var errSequenceNotFound = fmt.Errorf("diff: sequence not found on replication server")
+
+// fetchSequence downloads the OsmChange for the given replication
+// sequence number, following osmosis' nnn/nnn/nnn directory layout.
+func (u *Update) fetchSequence(seq int64) (io.ReadCloser, error) {
+	url := fmt.Sprintf("%s/%s.osc.gz", strings.TrimRight(u.conf.ReplicationURL, "/"), sequencePath(seq))
+	resp, err := u.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errSequenceNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s for %s", resp.Status, url)
+	}
+	return resp.Body, nil
+}
+
+// sequencePath formats seq as osmosis does, e.g. 123456789 ->
+// "123/456/789".
+func sequencePath(seq int64) string {
+	s := fmt.Sprintf("%09d", seq)
+	return s[0:3] + "/" + s[3:6] + "/" + s[6:9]
+}
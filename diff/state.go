@@ -0,0 +1,103 @@
+package diff
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stateFile is the name of the sequence tracker that is persisted under
+// cachedir. It follows the same key=value layout as osmosis' state.txt so
+// that an externally fetched state file can be copied in verbatim.
+const stateFile = "last.state.txt"
+
+// State records the replication sequence that was last applied to the
+// database, together with a timestamp. Update sets it to the local time
+// at which the sequence was applied, not to the replication server's
+// timestamp for that sequence.
+type State struct {
+	Sequence  int64
+	Timestamp time.Time
+}
+
+// StateTracker persists a State under cachedir so that -diffrun can resume
+// from the last successfully applied sequence after a restart.
+type StateTracker struct {
+	path string
+}
+
+// NewStateTracker returns a tracker that reads/writes its state file
+// underneath cacheDir.
+func NewStateTracker(cacheDir string) *StateTracker {
+	return &StateTracker{path: filepath.Join(cacheDir, stateFile)}
+}
+
+// Load reads the last persisted state. It returns a zero State and no
+// error if no state was persisted yet, so that a fresh cachedir starts
+// from sequence 0.
+func (t *StateTracker) Load() (State, error) {
+	f, err := os.Open(t.path)
+	if os.IsNotExist(err) {
+		return State{}, nil
+	} else if err != nil {
+		return State{}, err
+	}
+	defer f.Close()
+
+	var state State
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "sequenceNumber":
+			seq, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return State{}, fmt.Errorf("invalid sequenceNumber in %s: %v", t.path, err)
+			}
+			state.Sequence = seq
+		case "timestamp":
+			ts := strings.Replace(parts[1], "\\:", ":", -1)
+			parsed, err := time.Parse(time.RFC3339, ts)
+			if err != nil {
+				return State{}, fmt.Errorf("invalid timestamp in %s: %v", t.path, err)
+			}
+			state.Timestamp = parsed
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return State{}, err
+	}
+	return state, nil
+}
+
+// Save persists state, overwriting any previous state file.
+func (t *StateTracker) Save(state State) error {
+	tmp := t.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	ts := strings.Replace(state.Timestamp.UTC().Format(time.RFC3339), ":", "\\:", -1)
+	_, err = fmt.Fprintf(f, "#%s\nsequenceNumber=%d\ntimestamp=%s\n",
+		time.Now().UTC().Format(time.ANSIC), state.Sequence, ts)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
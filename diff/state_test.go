@@ -0,0 +1,70 @@
+package diff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateTrackerLoadMissing(t *testing.T) {
+	tracker := NewStateTracker(t.TempDir())
+
+	state, err := tracker.Load()
+	if err != nil {
+		t.Fatalf("Load on fresh cachedir: %v", err)
+	}
+	if state.Sequence != 0 {
+		t.Fatalf("expected zero-value state, got %+v", state)
+	}
+}
+
+func TestStateTrackerSaveLoadRoundTrip(t *testing.T) {
+	tracker := NewStateTracker(t.TempDir())
+	want := State{Sequence: 123456789, Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+
+	if err := tracker.Save(want); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	got, err := tracker.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got.Sequence != want.Sequence {
+		t.Errorf("Sequence = %d, want %d", got.Sequence, want.Sequence)
+	}
+	if !got.Timestamp.Equal(want.Timestamp) {
+		t.Errorf("Timestamp = %v, want %v", got.Timestamp, want.Timestamp)
+	}
+}
+
+func TestStateTrackerSaveOverwrites(t *testing.T) {
+	tracker := NewStateTracker(t.TempDir())
+
+	if err := tracker.Save(State{Sequence: 1, Timestamp: time.Now()}); err != nil {
+		t.Fatalf("first Save: %v", err)
+	}
+	if err := tracker.Save(State{Sequence: 2, Timestamp: time.Now()}); err != nil {
+		t.Fatalf("second Save: %v", err)
+	}
+
+	got, err := tracker.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got.Sequence != 2 {
+		t.Errorf("Sequence = %d, want 2", got.Sequence)
+	}
+}
+
+func TestSequencePath(t *testing.T) {
+	cases := map[int64]string{
+		123456789: "123/456/789",
+		1:         "000/000/001",
+		0:         "000/000/000",
+	}
+	for seq, want := range cases {
+		if got := sequencePath(seq); got != want {
+			t.Errorf("sequencePath(%d) = %q, want %q", seq, got, want)
+		}
+	}
+}
@@ -5,12 +5,14 @@ import (
 	"goposm/cache"
 	"goposm/database"
 	_ "goposm/database/postgis"
+	"goposm/diff"
 	"goposm/mapping"
 	"goposm/reader"
 	"goposm/stats"
 	"goposm/writer"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 	"runtime/pprof"
 	"strconv"
@@ -38,7 +40,11 @@ var (
 	read             = flag.String("read", "", "read")
 	write            = flag.Bool("write", false, "write")
 	connection       = flag.String("connection", "", "connection parameters")
-	diff             = flag.Bool("diff", false, "enable diff support")
+	diffFlag         = flag.Bool("diff", false, "enable diff support")
+	diffrun          = flag.Bool("diffrun", false, "import OsmChange files and apply them continuously")
+	diffdir          = flag.String("diffdir", "", "replication URL to read OsmChange (.osc.gz) files from")
+	diffinterval     = flag.Duration("diffinterval", 60*time.Second, "poll interval once -diffrun has caught up")
+	readerworkers    = flag.Int("readerworkers", 0, "number of PBF blocks decoded in parallel (0 = runtime.NumCPU())")
 	mappingFile      = flag.String("mapping", "", "mapping file")
 	deployProduction = flag.Bool("deployproduction", false, "deploy production")
 	revertDeploy     = flag.Bool("revertdeploy", false, "revert deploy to production")
@@ -113,14 +119,15 @@ func main() {
 
 	var db database.DB
 
-	if *write || *deployProduction || *revertDeploy || *removeBackup {
-		connType := database.ConnectionType(*connection)
+	if *write || *diffFlag || *diffrun || *deployProduction || *revertDeploy || *removeBackup {
+		connType := database.ConnectionTypeOf(*connection)
 		conf := database.Config{
 			Type:             connType,
 			ConnectionParams: *connection,
 			Srid:             3857,
+			Mapping:          tagmapping,
 		}
-		db, err = database.Open(conf, tagmapping)
+		db, err = database.Open(conf)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -128,7 +135,14 @@ func main() {
 
 	if *read != "" {
 		osmCache.Coords.SetLinearImport(true)
-		reader.ReadPbf(osmCache, progress, tagmapping, *read)
+		err = reader.ReadPbf(osmCache, progress, tagmapping, *read, reader.Config{
+			Workers:  *readerworkers,
+			CacheDir: *cachedir,
+			Resume:   *appendcache,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
 		osmCache.Coords.SetLinearImport(false)
 		progress.Reset()
 		osmCache.Coords.Flush()
@@ -215,6 +229,43 @@ func main() {
 			log.Fatal("database not deployable")
 		}
 	}
+
+	if *diffFlag || *diffrun {
+		if *diffdir == "" {
+			log.Fatal("-diff/-diffrun require -diffdir")
+		}
+
+		diffCache := cache.NewDiffCache(*cachedir)
+		if err = diffCache.Open(); err != nil {
+			log.Fatal(err)
+		}
+		defer diffCache.Close()
+
+		update := diff.NewUpdate(diff.Config{
+			ReplicationURL: *diffdir,
+			CacheDir:       *cachedir,
+			Interval:       *diffinterval,
+			BatchSize:      dbImportBatchSize,
+		}, osmCache, diffCache, db, tagmapping)
+
+		if *diffrun {
+			stop := make(chan struct{})
+			sigc := make(chan os.Signal, 1)
+			signal.Notify(sigc, os.Interrupt)
+			go func() {
+				<-sigc
+				log.Println("diffrun: shutting down")
+				close(stop)
+			}()
+
+			if err := update.Run(stop); err != nil {
+				log.Fatal(err)
+			}
+		} else if err := update.ApplyAvailable(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	progress.Stop()
 
 }
@@ -0,0 +1,27 @@
+package reader
+
+import (
+	"goposm/cache"
+	"goposm/mapping"
+	"goposm/parser/pbf"
+)
+
+// cacheBlock stores the nodes/ways/relations decoded from a single
+// PrimitiveBlock in osmCache. tagMapping is currently unused here (the
+// cache stores raw elements; tag matching happens in the writer
+// pipeline) but is threaded through so that a future coordinate- or
+// tag-based pre-filter can be added without changing the worker
+// signature again.
+func cacheBlock(osmCache *cache.OSMCache, tagMapping *mapping.Mapping, block pbf.Block) error {
+	if len(block.Nodes) > 0 {
+		osmCache.Nodes.PutNodes(block.Nodes)
+		osmCache.Coords.PutCoords(block.Nodes)
+	}
+	if len(block.Ways) > 0 {
+		osmCache.Ways.PutWays(block.Ways)
+	}
+	if len(block.Relations) > 0 {
+		osmCache.Relations.PutRelations(block.Relations)
+	}
+	return nil
+}
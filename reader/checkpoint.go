@@ -0,0 +1,103 @@
+package reader
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// checkpointFile returns the path of the block checkpoint for pbfPath,
+// stored under cacheDir so that -appendcache can find it again on the
+// next run. Checkpoints are keyed by the source file name: importing a
+// different .pbf into the same cachedir starts from scratch.
+func checkpointFile(cacheDir, pbfPath string) string {
+	return filepath.Join(cacheDir, "reader_checkpoint_"+filepath.Base(pbfPath)+".txt")
+}
+
+// checkpoint tracks, for a single PBF import, which PrimitiveBlocks have
+// already been cached. It is appended to after every block so that a
+// crash only loses the block currently in flight, not the whole import.
+type checkpoint struct {
+	path       string
+	lastIndex  int
+	lastOffset int64
+	f          *os.File
+}
+
+// openCheckpoint opens (creating if necessary) the checkpoint file for
+// pbfPath under cacheDir, ready for Mark to append to it.
+func openCheckpoint(cacheDir, pbfPath string) (*checkpoint, error) {
+	path := checkpointFile(cacheDir, pbfPath)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoint{path: path, lastIndex: -1, f: f}, nil
+}
+
+// loadCheckpoint reads the last completed block index and its file
+// offset, i.e. the offset to resume reading from. It returns
+// lastIndex == -1 if there is no usable checkpoint (fresh import, or
+// appendcache not requested).
+func loadCheckpoint(cacheDir, pbfPath string) (lastIndex int, resumeOffset int64, err error) {
+	path := checkpointFile(cacheDir, pbfPath)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return -1, 0, nil
+	} else if err != nil {
+		return -1, 0, err
+	}
+	defer f.Close()
+
+	lastIndex = -1
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		idx, err1 := strconv.Atoi(parts[0])
+		off, err2 := strconv.ParseInt(parts[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		// record offset is where the *next* block starts, so it can be
+		// used directly as a resume point.
+		lastIndex = idx
+		resumeOffset = off
+	}
+	if err := scanner.Err(); err != nil {
+		return -1, 0, err
+	}
+	return lastIndex, resumeOffset, nil
+}
+
+// Mark records that the block at blockIndex has been fully cached, and
+// that the next block to read starts at nextOffset.
+func (c *checkpoint) Mark(blockIndex int, nextOffset int64) error {
+	if _, err := fmt.Fprintf(c.f, "%d %d\n", blockIndex, nextOffset); err != nil {
+		return err
+	}
+	c.lastIndex = blockIndex
+	c.lastOffset = nextOffset
+	return nil
+}
+
+func (c *checkpoint) Close() error {
+	return c.f.Close()
+}
+
+// remove deletes the checkpoint, used once an import finishes
+// successfully so that a later, unrelated -read of the same file starts
+// fresh instead of being treated as a resume.
+func (c *checkpoint) remove() error {
+	c.f.Close()
+	err := os.Remove(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
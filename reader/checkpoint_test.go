@@ -0,0 +1,68 @@
+package reader
+
+import "testing"
+
+func TestLoadCheckpointMissing(t *testing.T) {
+	idx, offset, err := loadCheckpoint(t.TempDir(), "foo.pbf")
+	if err != nil {
+		t.Fatalf("loadCheckpoint on fresh cachedir: %v", err)
+	}
+	if idx != -1 || offset != 0 {
+		t.Fatalf("loadCheckpoint = (%d, %d), want (-1, 0)", idx, offset)
+	}
+}
+
+func TestCheckpointMarkAndResume(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	cp, err := openCheckpoint(cacheDir, "foo.pbf")
+	if err != nil {
+		t.Fatalf("openCheckpoint: %v", err)
+	}
+	for i, offset := 0, int64(100); i < 3; i, offset = i+1, offset+100 {
+		if err := cp.Mark(i, offset); err != nil {
+			t.Fatalf("Mark(%d, %d): %v", i, offset, err)
+		}
+	}
+	if err := cp.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	idx, offset, err := loadCheckpoint(cacheDir, "foo.pbf")
+	if err != nil {
+		t.Fatalf("loadCheckpoint: %v", err)
+	}
+	if idx != 2 || offset != 300 {
+		t.Fatalf("loadCheckpoint = (%d, %d), want (2, 300)", idx, offset)
+	}
+}
+
+func TestCheckpointRemove(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	cp, err := openCheckpoint(cacheDir, "foo.pbf")
+	if err != nil {
+		t.Fatalf("openCheckpoint: %v", err)
+	}
+	if err := cp.Mark(0, 100); err != nil {
+		t.Fatalf("Mark: %v", err)
+	}
+	if err := cp.remove(); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+
+	idx, _, err := loadCheckpoint(cacheDir, "foo.pbf")
+	if err != nil {
+		t.Fatalf("loadCheckpoint after remove: %v", err)
+	}
+	if idx != -1 {
+		t.Fatalf("loadCheckpoint after remove = %d, want -1 (fresh import)", idx)
+	}
+}
+
+func TestCheckpointFileIsKeyedByPath(t *testing.T) {
+	cacheDir := t.TempDir()
+	if checkpointFile(cacheDir, "a.pbf") == checkpointFile(cacheDir, "b.pbf") {
+		t.Fatal("checkpointFile must differ for different source files")
+	}
+}
@@ -0,0 +1,157 @@
+// Package reader reads nodes/ways/relations from a .pbf extract into an
+// OSMCache. Large, planet-sized extracts can take hours, so the reader
+// checkpoints its progress at block granularity and can resume after a
+// failure instead of restarting from the beginning of the file.
+package reader
+
+import (
+	"fmt"
+	"goposm/cache"
+	"goposm/mapping"
+	"goposm/parser/pbf"
+	"goposm/stats"
+	"log"
+	"runtime"
+	"sync"
+)
+
+// Config controls how ReadPbf parallelizes and checkpoints a read.
+type Config struct {
+	// Workers is the number of PrimitiveBlocks decoded concurrently.
+	// Zero means runtime.NumCPU(), decoupled from GOMAXPROCS so that it
+	// can be tuned independently of the process-wide scheduler setting.
+	// Decoding is the only step that runs on these workers; the decoded
+	// blocks are always written to osmCache by a single goroutine, in
+	// block order.
+	Workers int
+	// CacheDir is the -cachedir the checkpoint file is stored under.
+	CacheDir string
+	// Resume, when true (-appendcache), skips blocks already recorded
+	// in the checkpoint from a previous, interrupted run against the
+	// same path.
+	Resume bool
+}
+
+// ReadPbf decodes path and caches its nodes/ways/relations in osmCache,
+// reporting progress to progress in terms of blocks rather than
+// elements so that very large imports give meaningful, steady feedback.
+//
+// Raw blocks are decoded concurrently across conf.Workers goroutines,
+// but every write to osmCache happens from a single goroutine in block
+// order, matching the linear-import assumption osmCache.Coords relies
+// on (see SetLinearImport in the caller).
+func ReadPbf(osmCache *cache.OSMCache, progress *stats.Statistics,
+	tagMapping *mapping.Mapping, path string, conf Config) error {
+
+	workers := conf.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	cp, err := openCheckpoint(conf.CacheDir, path)
+	if err != nil {
+		return fmt.Errorf("reader: opening checkpoint: %v", err)
+	}
+	defer cp.Close()
+
+	startIndex := 0
+	var startOffset int64
+	if conf.Resume {
+		lastIndex, resumeOffset, err := loadCheckpoint(conf.CacheDir, path)
+		if err != nil {
+			return fmt.Errorf("reader: loading checkpoint: %v", err)
+		}
+		if lastIndex >= 0 {
+			startIndex = lastIndex + 1
+			startOffset = resumeOffset
+			log.Printf("reader: resuming %s from block %d (offset %d)", path, startIndex, startOffset)
+		}
+	}
+
+	it, err := pbf.NewBlockIterator(path, startOffset, startIndex)
+	if err != nil {
+		return fmt.Errorf("reader: opening %s: %v", path, err)
+	}
+	defer it.Close()
+
+	rawBlocks := it.RawBlocks()
+
+	var wg sync.WaitGroup
+	decoded := make(chan blockResult, workers)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for raw := range rawBlocks {
+				block, err := pbf.DecodeBlock(raw)
+				decoded <- blockResult{block: block, err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(decoded)
+	}()
+
+	// cacheBlocksInOrder is the only goroutine that ever writes to
+	// osmCache or the checkpoint, even though decoding above runs on
+	// conf.Workers goroutines in parallel.
+	return cacheBlocksInOrder(osmCache, tagMapping, cp, startIndex, decoded, progress)
+}
+
+// blockResult is what a decode worker reports back for a single block.
+type blockResult struct {
+	block pbf.Block
+	err   error
+}
+
+// cacheBlocksInOrder consumes decode results, which may complete out of
+// order across workers, and writes each block to osmCache strictly in
+// block order: caching a block out of order would break the
+// osmCache.Coords linear-import assumption, and checkpointing a gap
+// would let a later resume skip a block that never actually finished.
+func cacheBlocksInOrder(osmCache *cache.OSMCache, tagMapping *mapping.Mapping,
+	cp *checkpoint, startIndex int, decoded <-chan blockResult, progress *stats.Statistics) error {
+
+	pending := make(map[int]pbf.Block)
+	next := startIndex
+	var firstErr error
+
+	for res := range decoded {
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("reader: decoding block %d: %v", res.block.Index, res.err)
+			}
+			continue
+		}
+		pending[res.block.Index] = res.block
+
+		for {
+			block, ok := pending[next]
+			if !ok {
+				break
+			}
+			if err := cacheBlock(osmCache, tagMapping, block); err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("reader: caching block %d: %v", block.Index, err)
+				}
+			} else if firstErr == nil {
+				// no checkpoint past a block that failed to cache.
+				if err := cp.Mark(block.Index, block.NextOffset); err != nil {
+					firstErr = fmt.Errorf("reader: writing checkpoint: %v", err)
+				}
+			}
+			progress.AddBlocks(1)
+			delete(pending, next)
+			next++
+		}
+	}
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	// a fully successful read has nothing left to resume from.
+	return cp.remove()
+}